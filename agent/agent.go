@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/hashicorp/consul/agent/submatview"
+)
+
+// Config is the subset of the agent's runtime configuration consulted
+// directly by the HTTP handlers in this package.
+type Config struct {
+	Datacenter string
+}
+
+// Agent owns the long-lived subsystems the HTTP handlers in this package
+// are backed by.
+type Agent struct {
+	config *Config
+	logger hclog.Logger
+
+	// subscribeClient is the StreamClient used to open subscriptions
+	// against the server's event-publishing RPC.
+	subscribeClient submatview.StreamClient
+
+	submatviewStoreOnce sync.Once
+	submatviewStore     *submatview.Store
+}
+
+// NewAgent returns an Agent ready to serve the HTTP handlers in this
+// package.
+func NewAgent(config *Config, logger hclog.Logger, subscribeClient submatview.StreamClient) *Agent {
+	return &Agent{
+		config:          config,
+		logger:          logger,
+		subscribeClient: subscribeClient,
+	}
+}
+
+// eventStreamStore returns the Store backing /v1/event/stream, starting its
+// idle-eviction loop the first time it's requested.
+func (a *Agent) eventStreamStore() *submatview.Store {
+	a.submatviewStoreOnce.Do(func() {
+		a.submatviewStore = submatview.NewStore(a.logger.Named("submatview"))
+		go a.submatviewStore.Run(context.Background())
+	})
+	return a.submatviewStore
+}
+
+// eventStreamClient returns the StreamClient EventStream subscribes
+// through.
+func (a *Agent) eventStreamClient() submatview.StreamClient {
+	return a.subscribeClient
+}
+
+// InvalidateACLToken force-closes every /v1/event/stream subscription open
+// under secretID. It must be called by the ACL resolver whenever a token's
+// rules change or the token is deleted, so a subscription already running
+// under that token's now-stale authorization is torn down instead of
+// continuing to stream data the token can no longer access; the next
+// request for the same key re-authorizes and opens a fresh one.
+func (a *Agent) InvalidateACLToken(secretID string) {
+	a.eventStreamStore().InvalidateToken(secretID)
+}
+
+// HTTPHandlers implements the agent's HTTP API handlers. Only the
+// /v1/event/stream surface lives in this package slice; the rest of the v1
+// API is registered elsewhere.
+type HTTPHandlers struct {
+	agent *Agent
+}
+
+// NewHTTPHandlers returns an HTTPHandlers backed by agent.
+func NewHTTPHandlers(agent *Agent) *HTTPHandlers {
+	return &HTTPHandlers{agent: agent}
+}
+
+// BadRequestError marks an error as the client's fault, so the HTTP
+// dispatcher in registerEventStreamRoute reports it as a 400 instead of a
+// 500.
+type BadRequestError struct {
+	Reason string
+}
+
+func (e BadRequestError) Error() string {
+	return e.Reason
+}
+
+// registerEventStreamRoute wires EventStream into mux. Call it alongside
+// wherever the rest of the agent's v1 routes are registered.
+func registerEventStreamRoute(mux *http.ServeMux, s *HTTPHandlers) {
+	mux.HandleFunc("/v1/event/stream", func(resp http.ResponseWriter, req *http.Request) {
+		// EventStream writes its own response body directly (it's a
+		// long-lived streaming handler), so a nil, nil return means the
+		// response is already complete; only a non-nil error still needs
+		// writing out here.
+		if _, err := s.EventStream(resp, req); err != nil {
+			status := http.StatusInternalServerError
+			if _, ok := err.(BadRequestError); ok {
+				status = http.StatusBadRequest
+			}
+			http.Error(resp, err.Error(), status)
+		}
+	})
+}
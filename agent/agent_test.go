@@ -0,0 +1,16 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgent_InvalidateACLToken_NoPanicBeforeAnySubscription(t *testing.T) {
+	a := NewAgent(&Config{Datacenter: "dc1"}, hclog.New(nil), nil)
+
+	require.NotPanics(t, func() {
+		a.InvalidateACLToken("abcd")
+	}, "invalidating a token with no open subscriptions must be a harmless no-op")
+}
@@ -0,0 +1,301 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/hashicorp/consul/agent/cache"
+	"github.com/hashicorp/consul/agent/submatview"
+	"github.com/hashicorp/consul/proto/pbsubscribe"
+)
+
+// eventStreamHeartbeatInterval bounds how long an idle /v1/event/stream
+// connection can go without a frame, so proxies and load balancers sitting
+// in front of the agent don't time it out.
+const eventStreamHeartbeatInterval = 30 * time.Second
+
+// eventStreamFrame is a single newline-delimited JSON frame written to an
+// event stream response. A frame with no Events is a heartbeat.
+type eventStreamFrame struct {
+	Index  uint64             `json:"Index"`
+	Events []eventStreamEvent `json:"Events,omitempty"`
+}
+
+// eventStreamEvent is the wire representation of a single pbsubscribe.Event.
+type eventStreamEvent struct {
+	Topic   string
+	Type    string
+	Key     string
+	Payload interface{}
+}
+
+// EventStream handles GET /v1/event/stream?topic=&key=&index=&namespace=.
+// It long-polls submatview.Store for the Materializer matching topic+key,
+// and streams each batch of events it receives to the client as a
+// newline-delimited JSON frame, with periodic empty heartbeat frames to
+// keep the connection alive. This turns the internal materializer cache
+// into a user-facing change-event API, letting external tools tail Consul
+// catalog/config changes without reimplementing a streaming gRPC client.
+func (s *HTTPHandlers) EventStream(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	args, err := parseEventStreamArgs(req)
+	if err != nil {
+		return nil, BadRequestError{Reason: err.Error()}
+	}
+
+	flusher, ok := resp.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("streaming not supported by response writer")
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(http.StatusOK)
+
+	ctx := req.Context()
+	enc := json.NewEncoder(resp)
+
+	view := &eventStreamView{}
+	streamReq := &eventStreamRequest{
+		topic:     args.Topic,
+		key:       args.Key,
+		namespace: args.Namespace,
+		token:     args.Token,
+		datacenter: s.agent.config.Datacenter,
+		view:      view,
+		client:    s.agent.eventStreamClient(),
+		logger:    s.agent.logger.Named("event_stream"),
+	}
+
+	heartbeat := time.NewTicker(eventStreamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	index := args.Index
+	for {
+		type getResult struct {
+			result submatview.Result
+			err    error
+		}
+		resultCh := make(chan getResult, 1)
+		streamReq.index = index
+
+		go func() {
+			result, err := s.agent.eventStreamStore().Get(ctx, streamReq)
+			resultCh <- getResult{result, err}
+		}()
+
+		select {
+		case r := <-resultCh:
+			if r.err != nil {
+				if ctx.Err() != nil {
+					return nil, nil
+				}
+				if errors.Is(r.err, submatview.ErrResetRequired) {
+					// The Materializer already reset its View internally;
+					// resume as a fresh subscriber from index 0 instead of
+					// ending the connection over a condition the client
+					// can't do anything about.
+					index = 0
+					continue
+				}
+				return nil, r.err
+			}
+
+			events, _ := r.result.Value.([]*pbsubscribe.Event)
+			if err := enc.Encode(eventStreamFrame{Index: r.result.Index, Events: toStreamEvents(events)}); err != nil {
+				return nil, nil
+			}
+			flusher.Flush()
+			index = r.result.Index
+
+		case <-heartbeat.C:
+			if err := enc.Encode(eventStreamFrame{}); err != nil {
+				return nil, nil
+			}
+			flusher.Flush()
+
+		case <-ctx.Done():
+			return nil, nil
+		}
+	}
+}
+
+// eventStreamArgs are the parsed query parameters for EventStream.
+type eventStreamArgs struct {
+	Topic     pbsubscribe.Topic
+	Key       string
+	Namespace string
+	Token     string
+	Index     uint64
+}
+
+func parseEventStreamArgs(req *http.Request) (eventStreamArgs, error) {
+	q := req.URL.Query()
+
+	topicName := q.Get("topic")
+	if topicName == "" {
+		return eventStreamArgs{}, fmt.Errorf("topic query parameter is required")
+	}
+	topic, ok := pbsubscribe.Topic_value[topicName]
+	if !ok {
+		return eventStreamArgs{}, fmt.Errorf("unknown topic %q", topicName)
+	}
+
+	var index uint64
+	if raw := q.Get("index"); raw != "" {
+		parsed, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return eventStreamArgs{}, fmt.Errorf("invalid index query parameter: %w", err)
+		}
+		index = parsed
+	}
+
+	return eventStreamArgs{
+		Topic:     pbsubscribe.Topic(topic),
+		Key:       q.Get("key"),
+		Namespace: q.Get("namespace"),
+		Token:     req.Header.Get("X-Consul-Token"),
+		Index:     index,
+	}, nil
+}
+
+// eventStreamRequest implements submatview.Request for a single
+// topic+key subscription backing an EventStream connection.
+type eventStreamRequest struct {
+	topic      pbsubscribe.Topic
+	key        string
+	namespace  string
+	token      string
+	datacenter string
+	index      uint64
+
+	view   *eventStreamView
+	client submatview.StreamClient
+	logger hclog.Logger
+}
+
+func (r *eventStreamRequest) CacheInfo() cache.RequestInfo {
+	return cache.RequestInfo{
+		Key:        fmt.Sprintf("%s/%s/%s", r.topic, r.namespace, r.key),
+		Token:      r.token,
+		Datacenter: r.datacenter,
+		Timeout:    10 * time.Minute,
+		MinIndex:   r.index,
+	}
+}
+
+func (r *eventStreamRequest) Type() string {
+	return "agent.EventStream"
+}
+
+func (r *eventStreamRequest) NewMaterializer() *submatview.Materializer {
+	return submatview.NewMaterializer(submatview.Deps{
+		View:   r.view,
+		Client: r.client,
+		Logger: r.logger,
+		Request: func(index uint64) pbsubscribe.SubscribeRequest {
+			return pbsubscribe.SubscribeRequest{
+				Topic:      r.topic,
+				Key:        r.key,
+				Namespace:  r.namespace,
+				Token:      r.token,
+				Datacenter: r.datacenter,
+				Index:      index,
+			}
+		},
+	})
+}
+
+// eventStreamViewBufferSize bounds how many batches an eventStreamView
+// retains, the same way defaultBufferSize bounds a Materializer's own
+// eventBuffer: enough to let a caller that's momentarily behind still get its
+// full delta, without letting a caller that never polls pin an unbounded
+// amount of history in memory.
+const eventStreamViewBufferSize = 512
+
+// eventStreamBatch is one Update call's events, tagged with the index they
+// were delivered at so Result can filter to just the batches a given caller
+// hasn't seen yet.
+type eventStreamBatch struct {
+	index  uint64
+	events []*pbsubscribe.Event
+}
+
+// eventStreamView is a submatview.View that passes raw events straight
+// through to the caller instead of folding them into a materialized result,
+// so EventStream can re-serialize exactly what the subscription delivered.
+//
+// Store.Get shares one Materializer, and therefore one View, across every
+// connection resolving to the same topic+key: a naive "drain events on
+// Result" design loses events when two connections poll the same shared
+// buffer, since whichever one calls Result first empties it and the other
+// sees nothing. Retaining batches instead and filtering by each caller's own
+// minIndex in Result gives every connection its own delta from the single
+// shared event log, without needing a separate queue per connection.
+type eventStreamView struct {
+	mu      sync.Mutex
+	batches []eventStreamBatch
+}
+
+func (v *eventStreamView) Update(events []*pbsubscribe.Event) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var index uint64
+	for _, event := range events {
+		if event.Index > index {
+			index = event.Index
+		}
+	}
+
+	v.batches = append(v.batches, eventStreamBatch{index: index, events: events})
+	if len(v.batches) > eventStreamViewBufferSize {
+		v.batches = v.batches[len(v.batches)-eventStreamViewBufferSize:]
+	}
+	return nil
+}
+
+// Result returns the events from every retained batch after minIndex and up
+// to index. A caller whose minIndex has aged out of the retained window just
+// gets the oldest batches still available rather than an error: unlike the
+// Materializer's own buffer, missing a few stale events here only means a
+// smaller replay, not a correctness problem, since Index still advances
+// correctly either way.
+func (v *eventStreamView) Result(minIndex, index uint64) interface{} {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var events []*pbsubscribe.Event
+	for _, batch := range v.batches {
+		if batch.index <= minIndex || batch.index > index {
+			continue
+		}
+		events = append(events, batch.events...)
+	}
+	return events
+}
+
+func (v *eventStreamView) Reset() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.batches = nil
+}
+
+func toStreamEvents(events []*pbsubscribe.Event) []eventStreamEvent {
+	out := make([]eventStreamEvent, 0, len(events))
+	for _, event := range events {
+		payload := event.GetPayload()
+		out = append(out, eventStreamEvent{
+			Topic:   event.Topic.String(),
+			Type:    fmt.Sprintf("%T", payload),
+			Key:     event.Key,
+			Payload: payload,
+		})
+	}
+	return out
+}
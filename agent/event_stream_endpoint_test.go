@@ -0,0 +1,48 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashicorp/consul/proto/pbsubscribe"
+)
+
+func TestEventStreamView_ResultFiltersByCallerMinIndex(t *testing.T) {
+	v := &eventStreamView{}
+
+	require.NoError(t, v.Update([]*pbsubscribe.Event{{Index: 1}}))
+	require.NoError(t, v.Update([]*pbsubscribe.Event{{Index: 2}}))
+
+	got := v.Result(0, 2).([]*pbsubscribe.Event)
+	require.Len(t, got, 2, "events from both Update calls since minIndex must be delivered")
+
+	// Result doesn't drain: a caller re-asking with the same minIndex gets
+	// the same events again, and a caller who already advanced past index 2
+	// gets nothing new yet.
+	require.Len(t, v.Result(0, 2).([]*pbsubscribe.Event), 2)
+	require.Empty(t, v.Result(2, 2).([]*pbsubscribe.Event))
+
+	require.NoError(t, v.Update([]*pbsubscribe.Event{{Index: 3}}))
+	got = v.Result(2, 3).([]*pbsubscribe.Event)
+	require.Len(t, got, 1)
+
+	v.Reset()
+	require.Empty(t, v.Result(0, 3).([]*pbsubscribe.Event))
+}
+
+func TestEventStreamView_ConcurrentCallersEachGetTheirOwnDelta(t *testing.T) {
+	v := &eventStreamView{}
+
+	require.NoError(t, v.Update([]*pbsubscribe.Event{{Index: 1}}))
+
+	// Two connections sharing this View via the same Materializer entry: one
+	// already caught up to index 1, one still starting from 0. Both must see
+	// the batch that's relevant to them; neither should be starved because
+	// the other happened to call Result first.
+	caughtUp := v.Result(1, 1).([]*pbsubscribe.Event)
+	require.Empty(t, caughtUp)
+
+	fresh := v.Result(0, 1).([]*pbsubscribe.Event)
+	require.Len(t, fresh, 1, "a second caller's Result call must not come back empty just because another caller already read this batch")
+}
@@ -0,0 +1,374 @@
+package submatview
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/hashicorp/consul/agent/cache"
+	"github.com/hashicorp/consul/proto/pbsubscribe"
+)
+
+// View is implemented by cache-type specific logic that knows how to turn
+// a stream of events into a materialized result.
+type View interface {
+	// Update is called with a set of events every time the Materializer
+	// receives new events from its subscription. The first call will
+	// include a snapshot of events describing the current state.
+	Update(events []*pbsubscribe.Event) error
+
+	// Result returns the cache result based on the current state of the
+	// view and the given index. minIndex is the index the caller last saw;
+	// most View implementations materialize full state from scratch each
+	// call and simply ignore it, but a View whose Result is itself a raw
+	// event delta (for example eventStreamView) needs it to compute that
+	// caller's own delta instead of sharing one drain-on-read buffer across
+	// every caller of a shared Materializer.
+	Result(minIndex, index uint64) interface{}
+
+	// Reset clears the view back to its zero state, in preparation for a
+	// new snapshot to be delivered.
+	Reset()
+}
+
+// snapshotTracker is implemented by Views that need the Materializer's
+// EndOfSnapshot/NewSnapshotToFollow handling scoped per event.Topic rather
+// than treated as one indivisible state machine. multiView is the only
+// current implementer: it fans one Materializer out over several
+// independent per-topic subscriptions, so the first topic to finish its own
+// snapshot must not flip the combined Materializer to "caught up" while
+// sibling topics are still empty, and a NewSnapshotToFollow for one topic
+// must not discard every other topic's accumulated state.
+type snapshotTracker interface {
+	// HandleEndOfSnapshot records that topic has reached its own
+	// EndOfSnapshot, and reports whether every topic it tracks has now
+	// done so.
+	HandleEndOfSnapshot(topic pbsubscribe.Topic) (allCaughtUp bool)
+
+	// HandleNewSnapshotToFollow resets only the state associated with
+	// topic, leaving other topics' views untouched.
+	HandleNewSnapshotToFollow(topic pbsubscribe.Topic)
+}
+
+// StreamClient is the interface used by Materializer to open a streaming
+// subscription. It is satisfied by pbsubscribe.StateChangeSubscriptionClient.
+type StreamClient interface {
+	Subscribe(ctx context.Context, req *pbsubscribe.SubscribeRequest) (pbsubscribe.StateChangeSubscription_SubscribeClient, error)
+}
+
+// Deps are the dependencies needed to materialize a single View.
+type Deps struct {
+	View    View
+	Client  StreamClient
+	Logger  hclog.Logger
+	Request func(index uint64) pbsubscribe.SubscribeRequest
+}
+
+// Result is returned from a successful Get or Notify update.
+type Result struct {
+	Value interface{}
+	Index uint64
+}
+
+const (
+	retryWaitMin = 1 * time.Second
+	retryWaitMax = 1 * time.Minute
+)
+
+// Materializer consumes a stream of events for a single topic+key
+// subscription, applies them to a View, and lets callers block until the
+// View reaches a given index. It must be run with Run in a goroutine.
+//
+// Event delivery to waiters is backed by a bounded eventBuffer rather than a
+// single broadcast channel: each Get/Notify caller walks the buffer's linked
+// chain from its own position, so a caller blocked on an old index never
+// delays one that's already caught up.
+type Materializer struct {
+	deps Deps
+	buf  *eventBuffer
+
+	lock  sync.Mutex
+	index uint64
+	err   error
+
+	lastUpdateTime time.Time
+	snapshotTime   time.Time
+	resetCount     uint64
+	reconnectCount uint64
+}
+
+// NewMaterializer returns a Materializer ready to be Run.
+func NewMaterializer(deps Deps) *Materializer {
+	return &Materializer{
+		deps: deps,
+		buf:  newEventBuffer(defaultBufferSize, defaultBufferMaxAge),
+	}
+}
+
+// Run the Materializer until ctx is canceled, resubscribing with backoff
+// whenever the stream ends with an error.
+func (m *Materializer) Run(ctx context.Context) {
+	retryWait := retryWaitMin
+	for {
+		err := m.runSubscription(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		m.lock.Lock()
+		m.err = err
+		index := m.index
+		if err != nil {
+			m.reconnectCount++
+		}
+		m.lock.Unlock()
+
+		// Wake any waiter parked on the buffer so it re-checks m.err rather
+		// than blocking until the next real event, which may never come if
+		// retries are failing.
+		m.buf.Append(index, nil)
+
+		if err != nil {
+			m.deps.Logger.Error("subscription error, will retry", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryWait):
+		}
+
+		retryWait *= 2
+		if retryWait > retryWaitMax {
+			retryWait = retryWaitMax
+		}
+	}
+}
+
+func (m *Materializer) runSubscription(ctx context.Context) error {
+	m.lock.Lock()
+	index := m.index
+	m.lock.Unlock()
+
+	req := m.deps.Request(index)
+	stream, err := m.deps.Client.Subscribe(ctx, &req)
+	if err != nil {
+		return err
+	}
+
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if err := m.handleEvent(event); err != nil {
+			return err
+		}
+	}
+}
+
+// handleEvent applies a single event to the View and updates the
+// Materializer's notion of the current index.
+func (m *Materializer) handleEvent(event *pbsubscribe.Event) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	switch {
+	case event.GetEndOfSnapshot():
+		if tracker, ok := m.deps.View.(snapshotTracker); ok {
+			if !tracker.HandleEndOfSnapshot(event.Topic) {
+				// Other topics this Materializer covers haven't reached
+				// their own EndOfSnapshot yet; advancing m.index now would
+				// let a Get/Notify caller read a combined result that's
+				// missing those topics' data entirely, rather than a
+				// correctly empty one.
+				return nil
+			}
+		}
+		m.index = event.Index
+		m.snapshotTime = time.Now()
+		m.lastUpdateTime = m.snapshotTime
+		m.buf.Append(event.Index, nil)
+		return nil
+
+	case event.GetNewSnapshotToFollow():
+		if tracker, ok := m.deps.View.(snapshotTracker); ok {
+			// Only event.Topic needs to resync; sibling topics' views and
+			// the combined index are still valid, so don't force every
+			// waiter to reset over this.
+			tracker.HandleNewSnapshotToFollow(event.Topic)
+			return nil
+		}
+		m.deps.View.Reset()
+		m.resetCount++
+		// Mark the reset in the buffer so any subscriber mid-walk learns
+		// its accumulated state no longer matches the View before it sees
+		// the fresh snapshot that follows.
+		m.buf.AppendReset()
+		return nil
+	}
+
+	events := []*pbsubscribe.Event{event}
+	if batch := event.GetEventBatch(); batch != nil {
+		events = batch.Events
+	}
+
+	if err := m.deps.View.Update(events); err != nil {
+		return err
+	}
+
+	m.index = event.Index
+	m.lastUpdateTime = time.Now()
+	m.buf.Append(event.Index, events)
+	return nil
+}
+
+// Close marks the Materializer as failed with err, waking any Get/Notify
+// caller currently blocked on it so it returns err instead of continuing to
+// wait on a subscription that will never produce more data. It does not
+// stop Run; the caller is expected to cancel the context Run was started
+// with.
+func (m *Materializer) Close(err error) {
+	m.lock.Lock()
+	m.err = err
+	index := m.index
+	m.lock.Unlock()
+
+	m.buf.Append(index, nil)
+}
+
+// getFromView blocks until the View's index is greater than minIndex, then
+// returns a Result built from its current state. Waiting is done by walking
+// m.buf's chain from minIndex rather than blocking on a shared channel, so a
+// caller waiting on an old index doesn't delay one that's already caught up.
+func (m *Materializer) getFromView(ctx context.Context, minIndex uint64) (Result, error) {
+	m.lock.Lock()
+	index := m.index
+	viewErr := m.err
+	m.lock.Unlock()
+
+	if viewErr != nil {
+		return Result{}, viewErr
+	}
+	if index > 0 && index > minIndex {
+		// Already caught up. Check this before consulting the buffer at
+		// all: minIndex may well have aged out of the buffer's bounded
+		// retention by now, but that only matters to a caller that still
+		// needs to wait for new data, not one whose answer is already
+		// sitting in the View.
+		return Result{Value: m.deps.View.Result(minIndex, index), Index: index}, nil
+	}
+
+	item, err := m.buf.StartAfter(minIndex)
+	if err != nil {
+		// Either minIndex predates everything the buffer still retains, or
+		// (from item.Next below) the chain was walked into a reset marker
+		// left by a NewSnapshotToFollow event. Either way the caller's
+		// accumulated state no longer matches the View; it must call
+		// View.Reset (already done internally by handleEvent) and retry
+		// against a fresh snapshot rather than trust this wait.
+		return Result{}, err
+	}
+
+	for {
+		item, err = item.Next(ctx)
+		if err != nil {
+			return Result{}, err
+		}
+
+		m.lock.Lock()
+		index = m.index
+		viewErr = m.err
+		m.lock.Unlock()
+
+		if viewErr != nil {
+			return Result{}, viewErr
+		}
+
+		if index > 0 && index > minIndex {
+			return Result{Value: m.deps.View.Result(minIndex, index), Index: index}, nil
+		}
+	}
+}
+
+// notify delivers a cache.UpdateEvent to ch every time the View advances
+// past minIndex, until ctx is canceled.
+func (m *Materializer) notify(ctx context.Context, correlationID string, minIndex uint64, ch chan<- cache.UpdateEvent) {
+	for {
+		result, err := m.getFromView(ctx, minIndex)
+		if ctx.Err() != nil {
+			return
+		}
+
+		event := cache.UpdateEvent{
+			CorrelationID: correlationID,
+			Err:           err,
+		}
+		if err == nil {
+			minIndex = result.Index
+			event.Result = result.Value
+			event.Meta = cache.ResultMeta{Index: result.Index}
+		} else {
+			// minIndex is what made this call fail (it's stale, or the
+			// buffer was reset past it); keep it as-is would just repeat
+			// the same failure forever, so drop back to 0 and let the next
+			// iteration wait for fresh data instead of spinning.
+			minIndex = 0
+		}
+
+		select {
+		case ch <- event:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sizer is implemented by View implementations that can report a reasonably
+// accurate estimate of their current memory footprint. Views that don't
+// implement it report a MemoryEstimate of 0 in Stats.
+type sizer interface {
+	SizeBytes() uint64
+}
+
+// MaterializerStats is a point-in-time snapshot of a Materializer's runtime
+// metrics.
+type MaterializerStats struct {
+	Index          uint64
+	LastUpdateTime time.Time
+	ResetCount     uint64
+	ReconnectCount uint64
+	MemoryEstimate uint64
+
+	snapshotTime time.Time
+}
+
+// SnapshotAge returns how long ago the current snapshot was received.
+func (s MaterializerStats) SnapshotAge() time.Duration {
+	if s.snapshotTime.IsZero() {
+		return 0
+	}
+	return time.Since(s.snapshotTime)
+}
+
+// Stats returns a snapshot of the Materializer's runtime metrics.
+func (m *Materializer) Stats() MaterializerStats {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var memEstimate uint64
+	if s, ok := m.deps.View.(sizer); ok {
+		memEstimate = s.SizeBytes()
+	}
+
+	return MaterializerStats{
+		Index:          m.index,
+		LastUpdateTime: m.lastUpdateTime,
+		ResetCount:     m.resetCount,
+		ReconnectCount: m.reconnectCount,
+		MemoryEstimate: memEstimate,
+		snapshotTime:   m.snapshotTime,
+	}
+}
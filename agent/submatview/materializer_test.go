@@ -0,0 +1,42 @@
+package submatview
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashicorp/consul/proto/pbservice"
+	"github.com/hashicorp/consul/proto/pbsubscribe"
+)
+
+func TestMaterializer_getFromView_AlreadyCaughtUpPastPrunedBuffer(t *testing.T) {
+	mat := NewMaterializer(Deps{
+		View:   &fakeView{srvs: make(map[string]*pbservice.CheckServiceNode)},
+		Client: NewTestStreamingClient(""),
+		Logger: hclog.New(nil),
+		Request: func(index uint64) pbsubscribe.SubscribeRequest {
+			return pbsubscribe.SubscribeRequest{}
+		},
+	})
+
+	// Shrink the buffer so it's easy to prune past an old index directly,
+	// without waiting out defaultBufferSize/defaultBufferMaxAge.
+	mat.buf = newEventBuffer(1, time.Hour)
+
+	require.NoError(t, mat.handleEvent(newEndOfSnapshotEvent(1)))
+	require.NoError(t, mat.handleEvent(newEventServiceHealthRegister(2, 1, "srv1")))
+	require.NoError(t, mat.handleEvent(newEventServiceHealthRegister(3, 2, "srv1")))
+
+	_, err := mat.buf.StartAfter(1)
+	require.ErrorIs(t, err, ErrResetRequired, "test setup: index 1 must actually have been pruned")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	result, err := mat.getFromView(ctx, 1)
+	require.NoError(t, err, "a caller whose minIndex the View has already passed must not be penalized for a pruned buffer")
+	require.Equal(t, uint64(3), result.Index)
+}
@@ -0,0 +1,266 @@
+package submatview
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+
+	"github.com/hashicorp/consul/agent/cache"
+	"github.com/hashicorp/consul/proto/pbsubscribe"
+)
+
+// MultiViewFactory constructs the View responsible for applying events for
+// a single topic within a MultiRequest's combined subscription.
+type MultiViewFactory func(topic pbsubscribe.Topic) View
+
+// MultiRequest subscribes to several topics for the same key over a single
+// Materializer, rather than requiring callers to open one subscription per
+// topic. For example a caller that needs ServiceHealth, ConfigEntry, and
+// Intentions for the same service can use one MultiRequest and share the
+// underlying streams instead of paying for three.
+//
+// The proto layer doesn't yet support a single SubscribeRequest spanning
+// multiple topics, so today this opens one Subscribe call per topic and
+// merges them client-side; FilterKeys is applied as a client-side filter
+// shim for the same reason, matching the topic/filter model other
+// HashiCorp event-stream implementations use server-side.
+type MultiRequest struct {
+	Topics     []pbsubscribe.Topic
+	Key        string
+	Token      string
+	Datacenter string
+	Timeout    time.Duration
+	MinIndex   uint64
+
+	// FilterKeys, if set, restricts delivered events to those whose Key
+	// matches one of these, so a consumer watching a narrow set of keys
+	// doesn't pay to demultiplex events it doesn't care about.
+	FilterKeys []string
+
+	// NewViewForTopic builds the View that will receive events for a single
+	// topic in the combined subscription.
+	NewViewForTopic MultiViewFactory
+
+	Client StreamClient
+	Logger hclog.Logger
+}
+
+// CacheInfo implements Request.
+func (r *MultiRequest) CacheInfo() cache.RequestInfo {
+	return cache.RequestInfo{
+		Key:        makeMultiKey(r.Topics, r.Key),
+		Token:      r.Token,
+		Datacenter: r.Datacenter,
+		Timeout:    r.Timeout,
+		MinIndex:   r.MinIndex,
+	}
+}
+
+// Type implements Request.
+func (r *MultiRequest) Type() string {
+	return "submatview.MultiRequest"
+}
+
+// NewMaterializer implements Request.
+func (r *MultiRequest) NewMaterializer() *Materializer {
+	return NewMaterializer(Deps{
+		View: newMultiView(r.Topics, r.NewViewForTopic),
+		Client: &multiStreamClient{
+			client:     r.Client,
+			topics:     r.Topics,
+			filterKeys: r.FilterKeys,
+		},
+		Logger: r.Logger,
+		Request: func(index uint64) pbsubscribe.SubscribeRequest {
+			return pbsubscribe.SubscribeRequest{
+				Key:        r.Key,
+				Token:      r.Token,
+				Datacenter: r.Datacenter,
+				Index:      index,
+			}
+		},
+	})
+}
+
+func makeMultiKey(topics []pbsubscribe.Topic, key string) string {
+	parts := make([]string, 0, len(topics)+1)
+	for _, t := range topics {
+		parts = append(parts, t.String())
+	}
+	parts = append(parts, key)
+	return strings.Join(parts, "+")
+}
+
+// multiView demultiplexes events to a per-topic View, keyed by topic, and
+// presents the combined state as a single View so it can back an ordinary
+// Materializer.
+//
+// multiView also implements snapshotTracker: it fans one Materializer out
+// over several independent per-topic subscriptions (see multiStreamClient),
+// so EndOfSnapshot and NewSnapshotToFollow events arrive per topic rather
+// than once for the combined whole. pendingSnapshot tracks which topics
+// haven't yet finished their own snapshot, so the Materializer only advances
+// past that snapshot once every topic has.
+type multiView struct {
+	views map[pbsubscribe.Topic]View
+
+	pendingSnapshot map[pbsubscribe.Topic]struct{}
+}
+
+func newMultiView(topics []pbsubscribe.Topic, newView MultiViewFactory) *multiView {
+	views := make(map[pbsubscribe.Topic]View, len(topics))
+	pendingSnapshot := make(map[pbsubscribe.Topic]struct{}, len(topics))
+	for _, topic := range topics {
+		views[topic] = newView(topic)
+		pendingSnapshot[topic] = struct{}{}
+	}
+	return &multiView{views: views, pendingSnapshot: pendingSnapshot}
+}
+
+// Update implements View.
+func (v *multiView) Update(events []*pbsubscribe.Event) error {
+	byTopic := make(map[pbsubscribe.Topic][]*pbsubscribe.Event)
+	for _, event := range events {
+		byTopic[event.Topic] = append(byTopic[event.Topic], event)
+	}
+
+	for topic, topicEvents := range byTopic {
+		view, ok := v.views[topic]
+		if !ok {
+			continue
+		}
+		if err := view.Update(topicEvents); err != nil {
+			return fmt.Errorf("updating view for topic %s: %w", topic, err)
+		}
+	}
+	return nil
+}
+
+// Result implements View, returning a map of topic to that topic's own
+// Result.
+func (v *multiView) Result(minIndex, index uint64) interface{} {
+	result := make(map[pbsubscribe.Topic]interface{}, len(v.views))
+	for topic, view := range v.views {
+		result[topic] = view.Result(minIndex, index)
+	}
+	return result
+}
+
+// Reset implements View.
+func (v *multiView) Reset() {
+	for topic, view := range v.views {
+		view.Reset()
+		v.pendingSnapshot[topic] = struct{}{}
+	}
+}
+
+// HandleEndOfSnapshot implements snapshotTracker. It is only called from
+// Materializer.handleEvent, which serializes every call via its own lock, so
+// pendingSnapshot needs no locking of its own here.
+func (v *multiView) HandleEndOfSnapshot(topic pbsubscribe.Topic) (allCaughtUp bool) {
+	delete(v.pendingSnapshot, topic)
+	return len(v.pendingSnapshot) == 0
+}
+
+// HandleNewSnapshotToFollow implements snapshotTracker. Only topic's own
+// view is reset; sibling topics that don't need to resync keep their
+// accumulated state instead of being forced to restart alongside it.
+func (v *multiView) HandleNewSnapshotToFollow(topic pbsubscribe.Topic) {
+	v.pendingSnapshot[topic] = struct{}{}
+	if view, ok := v.views[topic]; ok {
+		view.Reset()
+	}
+}
+
+// multiStreamClient fans out a single Subscribe call into one upstream
+// Subscribe per topic, merging their events into one logical stream.
+type multiStreamClient struct {
+	client     StreamClient
+	topics     []pbsubscribe.Topic
+	filterKeys []string
+}
+
+// Subscribe implements StreamClient.
+func (c *multiStreamClient) Subscribe(ctx context.Context, req *pbsubscribe.SubscribeRequest) (pbsubscribe.StateChangeSubscription_SubscribeClient, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	merged := &mergedSubscription{
+		events: make(chan multiEventOrError, 32*len(c.topics)),
+		cancel: cancel,
+	}
+
+	for _, topic := range c.topics {
+		topicReq := *req
+		topicReq.Topic = topic
+
+		stream, err := c.client.Subscribe(ctx, &topicReq)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("subscribing to topic %s: %w", topic, err)
+		}
+
+		merged.wg.Add(1)
+		go merged.pump(stream, c.filterKeys)
+	}
+
+	return merged, nil
+}
+
+type multiEventOrError struct {
+	event *pbsubscribe.Event
+	err   error
+}
+
+// mergedSubscription presents the merged output of several per-topic
+// subscriptions as a single pbsubscribe.StateChangeSubscription_SubscribeClient.
+type mergedSubscription struct {
+	pbsubscribe.StateChangeSubscription_SubscribeClient
+
+	events chan multiEventOrError
+	wg     sync.WaitGroup
+	cancel func()
+}
+
+func (m *mergedSubscription) pump(stream pbsubscribe.StateChangeSubscription_SubscribeClient, filterKeys []string) {
+	defer m.wg.Done()
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			// One topic's stream ending doesn't mean the others are still
+			// worth reading: the caller is about to see this err from Recv
+			// and tear the whole subscription down, so cancel now rather
+			// than leaving sibling pumps (and their streams) running until
+			// some later Recv call notices. err is queued before canceling,
+			// so it, and not a sibling's context error, is what Recv returns.
+			m.events <- multiEventOrError{err: err}
+			m.cancel()
+			return
+		}
+		if !matchesFilterKeys(event, filterKeys) {
+			continue
+		}
+		m.events <- multiEventOrError{event: event}
+	}
+}
+
+// Recv implements pbsubscribe.StateChangeSubscription_SubscribeClient.
+func (m *mergedSubscription) Recv() (*pbsubscribe.Event, error) {
+	item := <-m.events
+	return item.event, item.err
+}
+
+func matchesFilterKeys(event *pbsubscribe.Event, filterKeys []string) bool {
+	if len(filterKeys) == 0 {
+		return true
+	}
+	for _, key := range filterKeys {
+		if event.Key == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package submatview
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hashicorp/consul/proto/pbsubscribe"
+)
+
+type countingView struct {
+	updates int
+	resets  int
+}
+
+func (v *countingView) Update(events []*pbsubscribe.Event) error {
+	v.updates += len(events)
+	return nil
+}
+
+func (v *countingView) Result(_, index uint64) interface{} {
+	return v.updates
+}
+
+func (v *countingView) Reset() {
+	v.resets++
+}
+
+func TestMultiView_DemuxesByTopic(t *testing.T) {
+	views := map[pbsubscribe.Topic]*countingView{
+		pbsubscribe.Topic_ServiceHealth: {},
+		pbsubscribe.Topic_ConfigEntry:   {},
+	}
+
+	v := newMultiView(
+		[]pbsubscribe.Topic{pbsubscribe.Topic_ServiceHealth, pbsubscribe.Topic_ConfigEntry},
+		func(topic pbsubscribe.Topic) View { return views[topic] },
+	)
+
+	err := v.Update([]*pbsubscribe.Event{
+		{Topic: pbsubscribe.Topic_ServiceHealth},
+		{Topic: pbsubscribe.Topic_ServiceHealth},
+		{Topic: pbsubscribe.Topic_ConfigEntry},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, 2, views[pbsubscribe.Topic_ServiceHealth].updates)
+	require.Equal(t, 1, views[pbsubscribe.Topic_ConfigEntry].updates)
+
+	result := v.Result(0, 1).(map[pbsubscribe.Topic]interface{})
+	require.Equal(t, 2, result[pbsubscribe.Topic_ServiceHealth])
+	require.Equal(t, 1, result[pbsubscribe.Topic_ConfigEntry])
+
+	v.Reset()
+	require.Equal(t, 1, views[pbsubscribe.Topic_ServiceHealth].resets)
+	require.Equal(t, 1, views[pbsubscribe.Topic_ConfigEntry].resets)
+}
+
+func TestMaterializer_MultiTopic_SnapshotLifecycleIsScopedPerTopic(t *testing.T) {
+	views := map[pbsubscribe.Topic]*countingView{
+		pbsubscribe.Topic_ServiceHealth: {},
+		pbsubscribe.Topic_ConfigEntry:   {},
+	}
+	view := newMultiView(
+		[]pbsubscribe.Topic{pbsubscribe.Topic_ServiceHealth, pbsubscribe.Topic_ConfigEntry},
+		func(topic pbsubscribe.Topic) View { return views[topic] },
+	)
+
+	mat := NewMaterializer(Deps{
+		View:   view,
+		Client: NewTestStreamingClient(""),
+		Logger: hclog.New(nil),
+		Request: func(index uint64) pbsubscribe.SubscribeRequest {
+			return pbsubscribe.SubscribeRequest{}
+		},
+	})
+
+	require.NoError(t, mat.handleEvent(&pbsubscribe.Event{
+		Topic:   pbsubscribe.Topic_ServiceHealth,
+		Index:   1,
+		Payload: &pbsubscribe.Event_EndOfSnapshot{EndOfSnapshot: true},
+	}))
+
+	mat.lock.Lock()
+	index := mat.index
+	mat.lock.Unlock()
+	require.Zero(t, index, "the combined index must not advance until every topic has reached its own EndOfSnapshot")
+
+	require.NoError(t, mat.handleEvent(&pbsubscribe.Event{
+		Topic:   pbsubscribe.Topic_ConfigEntry,
+		Index:   1,
+		Payload: &pbsubscribe.Event_EndOfSnapshot{EndOfSnapshot: true},
+	}))
+
+	mat.lock.Lock()
+	index = mat.index
+	mat.lock.Unlock()
+	require.Equal(t, uint64(1), index, "the combined index must advance once every topic has reached its own EndOfSnapshot")
+
+	require.NoError(t, mat.handleEvent(&pbsubscribe.Event{
+		Topic:   pbsubscribe.Topic_ServiceHealth,
+		Payload: &pbsubscribe.Event_NewSnapshotToFollow{NewSnapshotToFollow: true},
+	}))
+
+	require.Equal(t, 1, views[pbsubscribe.Topic_ServiceHealth].resets, "only the topic named in NewSnapshotToFollow should be reset")
+	require.Zero(t, views[pbsubscribe.Topic_ConfigEntry].resets, "a sibling topic's accumulated state must survive another topic's resync")
+
+	mat.lock.Lock()
+	index = mat.index
+	mat.lock.Unlock()
+	require.Equal(t, uint64(1), index, "a single topic's resync must not force every waiter to reset")
+}
+
+func TestMatchesFilterKeys(t *testing.T) {
+	require.True(t, matchesFilterKeys(&pbsubscribe.Event{Key: "web"}, nil))
+	require.True(t, matchesFilterKeys(&pbsubscribe.Event{Key: "web"}, []string{"web", "api"}))
+	require.False(t, matchesFilterKeys(&pbsubscribe.Event{Key: "db"}, []string{"web", "api"}))
+}
+
+func TestMakeMultiKey(t *testing.T) {
+	key := makeMultiKey([]pbsubscribe.Topic{pbsubscribe.Topic_ServiceHealth, pbsubscribe.Topic_ConfigEntry}, "web")
+	require.Contains(t, key, "web")
+}
+
+// fakeTopicStreamClient hands out one fakeTopicStream per Subscribe call, so
+// a test can fail an individual topic's stream and observe what happens to
+// the others.
+type fakeTopicStreamClient struct {
+	streams map[pbsubscribe.Topic]*fakeTopicStream
+}
+
+func (c *fakeTopicStreamClient) Subscribe(ctx context.Context, req *pbsubscribe.SubscribeRequest) (pbsubscribe.StateChangeSubscription_SubscribeClient, error) {
+	s := c.streams[req.Topic]
+	s.ctx = ctx
+	return s, nil
+}
+
+type fakeTopicStream struct {
+	pbsubscribe.StateChangeSubscription_SubscribeClient
+	ctx  context.Context
+	err  error
+	done chan struct{}
+}
+
+func (s *fakeTopicStream) Recv() (*pbsubscribe.Event, error) {
+	select {
+	case <-s.done:
+		return nil, s.err
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+}
+
+func TestMultiStreamClient_ErrorOnOneTopicCancelsTheOthers(t *testing.T) {
+	healthStream := &fakeTopicStream{done: make(chan struct{})}
+	configStream := &fakeTopicStream{done: make(chan struct{})}
+
+	client := &multiStreamClient{
+		client: &fakeTopicStreamClient{streams: map[pbsubscribe.Topic]*fakeTopicStream{
+			pbsubscribe.Topic_ServiceHealth: healthStream,
+			pbsubscribe.Topic_ConfigEntry:   configStream,
+		}},
+		topics: []pbsubscribe.Topic{pbsubscribe.Topic_ServiceHealth, pbsubscribe.Topic_ConfigEntry},
+	}
+
+	stream, err := client.Subscribe(context.Background(), &pbsubscribe.SubscribeRequest{})
+	require.NoError(t, err)
+
+	boom := errors.New("boom")
+	healthStream.err = boom
+	close(healthStream.done)
+
+	_, err = stream.Recv()
+	require.ErrorIs(t, err, boom)
+
+	// The health topic's error should have canceled the shared subscription
+	// context, so the still-running config stream unblocks with ctx.Err()
+	// instead of leaking its pump goroutine forever.
+	require.Eventually(t, func() bool {
+		return configStream.ctx.Err() != nil
+	}, time.Second, time.Millisecond, "expected the config topic's stream to be canceled")
+}
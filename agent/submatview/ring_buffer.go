@@ -0,0 +1,181 @@
+package submatview
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/consul/proto/pbsubscribe"
+)
+
+const (
+	// defaultBufferSize bounds how many items (one per Append, each holding
+	// the events of one update) a Materializer retains in its eventBuffer. It is large enough to let a momentarily slow subscriber
+	// catch up without falling back to a full snapshot, but small enough
+	// that a subscriber that never catches up can't grow the buffer
+	// unbounded.
+	defaultBufferSize = 512
+
+	// defaultBufferMaxAge bounds how long a retained item is kept around
+	// regardless of defaultBufferSize, so that a quiet topic doesn't pin an
+	// arbitrarily old item in memory forever.
+	defaultBufferMaxAge = 10 * time.Minute
+)
+
+// ErrResetRequired is surfaced to a subscriber that asks to resume from an
+// index older than anything eventBuffer still retains, or that walks into a
+// reset marker left by a NewSnapshotToFollow event. The subscriber must call
+// View.Reset and restart from a fresh snapshot subscription rather than
+// trust its existing state. It is exported so callers outside this package
+// (for example an HTTP handler polling Store.Get) can recognize it and
+// retry instead of treating it as a fatal error.
+var ErrResetRequired = errors.New("submatview: buffer reset required, subscriber has fallen too far behind")
+
+// bufferItem is a single link in an eventBuffer's chain. next is populated
+// exactly once, by whichever goroutine appends the following item; closing
+// nextCh wakes every goroutine parked in Next, so one slow subscriber
+// blocked on an old item never stalls the rest.
+type bufferItem struct {
+	index     uint64
+	events    []*pbsubscribe.Event
+	err       error
+	createdAt time.Time
+
+	next   atomic.Value // *bufferItem
+	nextCh chan struct{}
+}
+
+func newBufferItem(index uint64, events []*pbsubscribe.Event, err error) *bufferItem {
+	return &bufferItem{
+		index:     index,
+		events:    events,
+		err:       err,
+		createdAt: time.Now(),
+		nextCh:    make(chan struct{}),
+	}
+}
+
+// Next blocks until the item following i is appended, or ctx is done. If the
+// following item carries a non-nil err (for example the ErrResetRequired
+// marker left by AppendReset), Next returns that err instead of the item,
+// since its payload isn't meant to be read as data.
+func (i *bufferItem) Next(ctx context.Context) (*bufferItem, error) {
+	select {
+	case <-i.nextCh:
+		next := i.next.Load().(*bufferItem)
+		if next.err != nil {
+			return nil, next.err
+		}
+		return next, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// link appends next after i and wakes any goroutine parked in Next.
+func (i *bufferItem) link(next *bufferItem) {
+	i.next.Store(next)
+	close(i.nextCh)
+}
+
+// eventBuffer is a bounded, index-linked ring buffer of bufferItems used to
+// deliver change events to subscribers without a single broadcast channel
+// stalling on the slowest reader. New items are appended at the tail; once
+// the buffer holds more than maxSize items, or its oldest item is older than
+// maxAge, the head is advanced, dropping the oldest link. A subscriber that
+// already holds a bufferItem keeps walking forward through it unaffected by
+// head advancing; only a subscriber resuming from a stale index is turned
+// away with ErrResetRequired.
+type eventBuffer struct {
+	maxSize int
+	maxAge  time.Duration
+
+	mu   sync.Mutex
+	head *bufferItem
+	tail *bufferItem
+	size int
+}
+
+func newEventBuffer(maxSize int, maxAge time.Duration) *eventBuffer {
+	// sentinel is never exposed to callers as real data (its index is 0, and
+	// bufferItem.index == 0 is never greater than a subscriber's MinIndex),
+	// it only exists so Head and StartAfter always have an item to return,
+	// even before the first real event has been appended.
+	sentinel := newBufferItem(0, nil, nil)
+	return &eventBuffer{maxSize: maxSize, maxAge: maxAge, head: sentinel, tail: sentinel, size: 1}
+}
+
+// Append adds a new item to the tail of the buffer, pruning the head if the
+// buffer has grown past its configured bounds, and returns the new item.
+func (b *eventBuffer) Append(index uint64, events []*pbsubscribe.Event) *bufferItem {
+	return b.appendItem(newBufferItem(index, events, nil))
+}
+
+// AppendReset appends a marker item carrying ErrResetRequired, used when a
+// NewSnapshotToFollow event means the View is about to be reset so that any
+// subscriber mid-walk learns its baseline is no longer valid.
+func (b *eventBuffer) AppendReset() *bufferItem {
+	return b.appendItem(newBufferItem(0, nil, ErrResetRequired))
+}
+
+func (b *eventBuffer) appendItem(item *bufferItem) *bufferItem {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tail.link(item)
+	b.tail = item
+	b.size++
+
+	for b.size > 1 && (b.size > b.maxSize || b.headExpiredLocked()) {
+		next, ok := b.head.next.Load().(*bufferItem)
+		if !ok || next == nil {
+			break
+		}
+		b.head = next
+		b.size--
+	}
+
+	return item
+}
+
+func (b *eventBuffer) headExpiredLocked() bool {
+	return time.Since(b.head.createdAt) > b.maxAge
+}
+
+// Head returns the oldest item still retained by the buffer.
+func (b *eventBuffer) Head() *bufferItem {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.head
+}
+
+// StartAfter returns the item to resume walking from for a subscriber whose
+// last-seen index is minIndex, or ErrResetRequired if minIndex predates the
+// oldest entry the buffer still retains. minIndex == 0 means the subscriber
+// has no prior position to lose, so it always just starts from the current
+// head rather than being told to reset.
+func (b *eventBuffer) StartAfter(minIndex uint64) (*bufferItem, error) {
+	b.mu.Lock()
+	head := b.head
+	b.mu.Unlock()
+
+	if minIndex > 0 && minIndex < head.index {
+		return nil, ErrResetRequired
+	}
+
+	item := head
+	for item.index != 0 && item.index <= minIndex {
+		next, ok := item.next.Load().(*bufferItem)
+		if !ok || next == nil || next.index > minIndex {
+			break
+		}
+		item = next
+	}
+
+	if item.err != nil {
+		return nil, item.err
+	}
+	return item, nil
+}
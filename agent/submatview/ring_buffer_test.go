@@ -0,0 +1,97 @@
+package submatview
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventBuffer_AppendAndWalk(t *testing.T) {
+	buf := newEventBuffer(10, time.Hour)
+
+	item := buf.Head()
+	buf.Append(1, nil)
+	buf.Append(2, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	next, err := item.Next(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), next.index)
+
+	next, err = next.Next(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), next.index)
+}
+
+func TestEventBuffer_EvictsPastMaxSize(t *testing.T) {
+	buf := newEventBuffer(2, time.Hour)
+
+	for i := uint64(1); i <= 5; i++ {
+		buf.Append(i, nil)
+	}
+
+	// Only the most recent 2 items should still be retained.
+	require.Equal(t, uint64(4), buf.Head().index)
+}
+
+func TestEventBuffer_StartAfter(t *testing.T) {
+	buf := newEventBuffer(2, time.Hour)
+	for i := uint64(1); i <= 5; i++ {
+		buf.Append(i, nil)
+	}
+
+	t.Run("resumes from a retained index", func(t *testing.T) {
+		item, err := buf.StartAfter(4)
+		require.NoError(t, err)
+		require.Equal(t, uint64(4), item.index)
+	})
+
+	t.Run("resumes from zero before anything was appended", func(t *testing.T) {
+		fresh := newEventBuffer(2, time.Hour)
+		item, err := fresh.StartAfter(0)
+		require.NoError(t, err)
+		require.Equal(t, uint64(0), item.index)
+	})
+
+	t.Run("pruned index requires a reset", func(t *testing.T) {
+		_, err := buf.StartAfter(1)
+		require.ErrorIs(t, err, ErrResetRequired)
+	})
+
+	t.Run("zero never requires a reset, even once the sentinel is pruned", func(t *testing.T) {
+		// minIndex 0 means the caller has no prior position that could have
+		// been invalidated, so it should always resolve to the current
+		// head rather than be turned away.
+		item, err := buf.StartAfter(0)
+		require.NoError(t, err)
+		require.Equal(t, buf.Head(), item)
+	})
+}
+
+func TestEventBuffer_AppendReset(t *testing.T) {
+	buf := newEventBuffer(10, time.Hour)
+
+	item := buf.Head()
+	buf.Append(1, nil)
+	reset := buf.AppendReset()
+	buf.Append(2, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	next, err := item.Next(ctx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), next.index)
+
+	// Next surfaces the reset marker's error instead of returning it as a
+	// walkable item, so a caller blocked here learns it must reset rather
+	// than silently reading past the marker.
+	next, err = next.Next(ctx)
+	require.Nil(t, next)
+	require.ErrorIs(t, err, ErrResetRequired)
+	require.ErrorIs(t, reset.err, ErrResetRequired)
+}
@@ -0,0 +1,292 @@
+package submatview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-metrics"
+
+	"github.com/hashicorp/consul/agent/cache"
+	"github.com/hashicorp/consul/lib/ttlcache"
+)
+
+// ErrSubscriptionClosed is returned to a blocked Get/Notify caller when the
+// entry it was waiting on is force-closed by InvalidateToken. The caller
+// should retry the request so it re-authorizes and opens a fresh
+// subscription against the token's current rules.
+var ErrSubscriptionClosed = errors.New("subscription closed due to ACL token invalidation")
+
+// idleTTL is how long an entry remains in the Store after its last request
+// stops watching it, before it is evicted and its Materializer stopped.
+const idleTTL = 20 * time.Minute
+
+// metricsInterval controls how often Store.Run emits the per-entry gauges
+// built from Stats.
+const metricsInterval = 9 * time.Second
+
+// Request is implemented by cache requests that support fetching their
+// results from a Store-managed Materializer, rather than a single round
+// trip to the servers.
+type Request interface {
+	CacheInfo() cache.RequestInfo
+	Type() string
+	NewMaterializer() *Materializer
+}
+
+// Store is a do-it-yourself in-memory cache for streaming data whose entries
+// are read through two operations, Get and Notify. Each entry in the Store is kept
+// up to date by a Materializer, which is responsible for subscribing to
+// change events and applying them to its View.
+//
+// Store is safe for concurrent use.
+type Store struct {
+	logger hclog.Logger
+
+	lock       sync.RWMutex
+	byKey      map[string]*entry
+	expiryHeap *ttlcache.ExpiryHeap
+}
+
+// entry tracks a single Materializer and the bookkeeping needed to manage
+// its lifecycle within the Store.
+type entry struct {
+	materializer *Materializer
+	requestType  string
+	token        string
+	requests     int
+	expiry       *ttlcache.Entry
+	stop         func()
+	done         <-chan struct{}
+
+	// invalidated is set by InvalidateToken once it has removed this entry
+	// from byKey, so that a Get/Notify call already in flight against it
+	// knows not to re-push its (now orphaned) expiry onto the heap when it
+	// finishes: the key may already have been re-created for a fresh entry
+	// by then, and pushing the old expiry would let expireLocked tear that
+	// unrelated, newer entry down instead.
+	invalidated bool
+}
+
+// NewStore creates and returns a Store that is ready for use. The caller
+// must call Run in a goroutine to evict idle entries.
+func NewStore(logger hclog.Logger) *Store {
+	return &Store{
+		logger:     logger,
+		byKey:      make(map[string]*entry),
+		expiryHeap: ttlcache.NewExpiryHeap(),
+	}
+}
+
+// Run evicts idle entries and emits metrics until ctx is canceled.
+func (s *Store) Run(ctx context.Context) {
+	metricsTicker := time.NewTicker(metricsInterval)
+	defer metricsTicker.Stop()
+
+	for {
+		s.lock.RLock()
+		next := s.expiryHeap.Next()
+		s.lock.RUnlock()
+
+		select {
+		case <-next.Wait:
+			s.lock.Lock()
+			s.expireLocked(next.Entry)
+			s.lock.Unlock()
+
+		case <-s.expiryHeap.NotifyCh:
+			continue
+
+		case <-metricsTicker.C:
+			s.emitMetrics()
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Get returns the result currently stored for req, blocking if necessary
+// until the view's index is past req.CacheInfo().MinIndex.
+func (s *Store) Get(ctx context.Context, req Request) (Result, error) {
+	s.lock.Lock()
+	e := s.getEntryLocked(req)
+	e.requests++
+	s.lock.Unlock()
+
+	defer func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		e.requests--
+		if e.requests == 0 && !e.invalidated {
+			s.expiryHeap.Push(e.expiry, idleTTL)
+		}
+	}()
+
+	return e.materializer.getFromView(ctx, req.CacheInfo().MinIndex)
+}
+
+// Notify registers ch to receive a cache.UpdateEvent, identified by
+// correlationID, every time req's view changes, until ctx is canceled.
+func (s *Store) Notify(ctx context.Context, req Request, correlationID string, ch chan<- cache.UpdateEvent) error {
+	s.lock.Lock()
+	e := s.getEntryLocked(req)
+	e.requests++
+	s.lock.Unlock()
+
+	go func() {
+		e.materializer.notify(ctx, correlationID, req.CacheInfo().MinIndex, ch)
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		e.requests--
+		if e.requests == 0 && !e.invalidated {
+			s.expiryHeap.Push(e.expiry, idleTTL)
+		}
+	}()
+
+	return nil
+}
+
+// getEntryLocked returns the entry for req, creating it (and starting its
+// Materializer) if necessary. s.lock must be held for writing.
+func (s *Store) getEntryLocked(req Request) *entry {
+	key := makeEntryKey(req.Type(), req.CacheInfo())
+
+	e, ok := s.byKey[key]
+	if ok {
+		if idx := e.expiry.Index(); idx != ttlcache.NotIndexed {
+			s.expiryHeap.Remove(idx)
+		}
+		return e
+	}
+
+	mat := req.NewMaterializer()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mat.Run(ctx)
+	}()
+
+	e = &entry{
+		materializer: mat,
+		requestType:  req.Type(),
+		token:        req.CacheInfo().Token,
+		expiry:       ttlcache.NewEntry(key),
+		stop:         cancel,
+		done:         done,
+	}
+	s.byKey[key] = e
+	return e
+}
+
+// InvalidateToken force-closes every entry whose subscription was opened
+// with secretID, so that any Get/Notify caller currently blocked on one
+// unblocks with ErrSubscriptionClosed instead of continuing to read from a
+// subscription the ACL token can no longer authorize, and so that the next
+// request for the same key re-authorizes and opens a fresh subscription.
+// It should be called by an ACL resolver hook whenever a token's rules
+// change or the token is deleted.
+func (s *Store) InvalidateToken(secretID string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for key, e := range s.byKey {
+		if e.token != secretID {
+			continue
+		}
+
+		e.materializer.Close(ErrSubscriptionClosed)
+		e.stop()
+		e.invalidated = true
+
+		if idx := e.expiry.Index(); idx != ttlcache.NotIndexed {
+			s.expiryHeap.Remove(idx)
+		}
+		delete(s.byKey, key)
+	}
+}
+
+// expireLocked stops and removes the entry tracked by expiryEntry, if it is
+// still idle. s.lock must be held for writing.
+func (s *Store) expireLocked(expiryEntry *ttlcache.Entry) {
+	if expiryEntry == nil {
+		return
+	}
+
+	e, ok := s.byKey[expiryEntry.Key]
+	if !ok || e.requests != 0 {
+		return
+	}
+
+	if idx := expiryEntry.Index(); idx != ttlcache.NotIndexed {
+		s.expiryHeap.Remove(idx)
+	}
+
+	e.stop()
+	delete(s.byKey, expiryEntry.Key)
+}
+
+func makeEntryKey(typ string, info cache.RequestInfo) string {
+	return fmt.Sprintf("%s/%s/%s/%s", typ, info.Datacenter, info.Token, info.Key)
+}
+
+// EntryStats is a point-in-time snapshot of a single Store entry's runtime
+// state, returned by Stats so operators can tell how many materializers are
+// alive, how far behind each has fallen, and which are churning through
+// reconnects.
+type EntryStats struct {
+	Key            string
+	RequestType    string
+	Subscribers    int
+	Index          uint64
+	LastUpdateTime time.Time
+	SnapshotAge    time.Duration
+	ResetCount     uint64
+	ReconnectCount uint64
+	MemoryEstimate uint64
+}
+
+// Stats returns a snapshot of every entry currently tracked by the Store.
+func (s *Store) Stats() []EntryStats {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	stats := make([]EntryStats, 0, len(s.byKey))
+	for key, e := range s.byKey {
+		ms := e.materializer.Stats()
+		stats = append(stats, EntryStats{
+			Key:            key,
+			RequestType:    e.requestType,
+			Subscribers:    e.requests,
+			Index:          ms.Index,
+			LastUpdateTime: ms.LastUpdateTime,
+			SnapshotAge:    ms.SnapshotAge(),
+			ResetCount:     ms.ResetCount,
+			ReconnectCount: ms.ReconnectCount,
+			MemoryEstimate: ms.MemoryEstimate,
+		})
+	}
+	return stats
+}
+
+// emitMetrics sets, for each entry, gauges of its subscriber count, snapshot
+// age, reset and reconnect counts and memory estimate, labelled only with the
+// entry's request type. Entries of the same request type write to the same
+// gauges, so the last one written wins; no gauge counts the entries, and
+// neither Index nor LastUpdateTime is emitted.
+func (s *Store) emitMetrics() {
+	for _, stat := range s.Stats() {
+		labels := []metrics.Label{{Name: "request_type", Value: stat.RequestType}}
+
+		metrics.SetGaugeWithLabels([]string{"consul", "submatview", "subscribers"}, float32(stat.Subscribers), labels)
+		metrics.SetGaugeWithLabels([]string{"consul", "submatview", "snapshot_age_seconds"}, float32(stat.SnapshotAge.Seconds()), labels)
+		metrics.SetGaugeWithLabels([]string{"consul", "submatview", "reset_count"}, float32(stat.ResetCount), labels)
+		metrics.SetGaugeWithLabels([]string{"consul", "submatview", "reconnect_count"}, float32(stat.ReconnectCount), labels)
+		metrics.SetGaugeWithLabels([]string{"consul", "submatview", "memory_estimate_bytes"}, float32(stat.MemoryEstimate), labels)
+	}
+}
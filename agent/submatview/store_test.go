@@ -188,7 +188,7 @@ func (f *fakeView) Update(events []*pbsubscribe.Event) error {
 	return nil
 }
 
-func (f *fakeView) Result(index uint64) interface{} {
+func (f *fakeView) Result(_, index uint64) interface{} {
 	srvs := make([]*pbservice.CheckServiceNode, 0, len(f.srvs))
 	for _, srv := range f.srvs {
 		srvs = append(srvs, srv)
@@ -243,6 +243,155 @@ func TestStore_Notify(t *testing.T) {
 
 // TODO: TestStore_GetWithNotify
 
+func TestStore_Get_ResetRequired(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewStore(hclog.New(nil))
+	go store.Run(ctx)
+
+	req := &fakeRequest{
+		client: NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace),
+	}
+	req.client.QueueEvents(
+		newEndOfSnapshotEvent(2),
+		newEventServiceHealthRegister(10, 1, "srv1"))
+
+	runStep(t, "populates the view with an initial snapshot", func(t *testing.T) {
+		req.index = 0
+		result, err := store.Get(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, uint64(10), result.Index)
+	})
+
+	runStep(t, "a reset mid-stream is delivered to a blocked caller", func(t *testing.T) {
+		req.index = 11
+
+		chResult := make(chan resultOrError, 1)
+		go func() {
+			result, err := store.Get(ctx, req)
+			chResult <- resultOrError{Result: result, Err: err}
+		}()
+
+		require.Eventually(t, func() bool {
+			store.lock.Lock()
+			defer store.lock.Unlock()
+			e, ok := store.byKey[makeEntryKey(req.Type(), req.CacheInfo())]
+			return ok && e.requests == 1
+		}, time.Second, time.Millisecond, "expected Get to block and register itself")
+
+		req.client.QueueEvents(newNewSnapshotToFollowEvent())
+
+		var getResult resultOrError
+		select {
+		case getResult = <-chResult:
+		case <-time.After(time.Second):
+			t.Fatalf("expected Get to unblock when the view was reset")
+		}
+		require.ErrorIs(t, getResult.Err, ErrResetRequired)
+	})
+}
+
+func TestStore_InvalidateToken(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := NewStore(hclog.New(nil))
+	go store.Run(ctx)
+
+	req := &fakeRequest{
+		client: NewTestStreamingClient(pbcommon.DefaultEnterpriseMeta.Namespace),
+	}
+	req.client.QueueEvents(
+		newEndOfSnapshotEvent(2),
+		newEventServiceHealthRegister(10, 1, "srv1"))
+
+	runStep(t, "revokes the token mid-stream", func(t *testing.T) {
+		req.index = 0
+		_, err := store.Get(ctx, req)
+		require.NoError(t, err)
+
+		req.index = 99
+		chResult := make(chan resultOrError, 1)
+		go func() {
+			result, err := store.Get(ctx, req)
+			chResult <- resultOrError{Result: result, Err: err}
+		}()
+
+		require.Eventually(t, func() bool {
+			store.lock.Lock()
+			defer store.lock.Unlock()
+			e, ok := store.byKey[makeEntryKey(req.Type(), req.CacheInfo())]
+			return ok && e.requests == 1
+		}, time.Second, time.Millisecond, "expected Get to block and register itself")
+
+		store.InvalidateToken("abcd")
+
+		var getResult resultOrError
+		select {
+		case getResult = <-chResult:
+		case <-time.After(time.Second):
+			t.Fatalf("expected Get to unblock when its token was invalidated")
+		}
+		require.ErrorIs(t, getResult.Err, ErrSubscriptionClosed)
+
+		store.lock.Lock()
+		_, ok := store.byKey[makeEntryKey(req.Type(), req.CacheInfo())]
+		store.lock.Unlock()
+		require.False(t, ok, "entry should be torn down after invalidation")
+	})
+
+	runStep(t, "re-established on the next request", func(t *testing.T) {
+		req.index = 0
+		req.client.QueueEvents(
+			newEndOfSnapshotEvent(2),
+			newEventServiceHealthRegister(10, 1, "srv1"))
+
+		result, err := store.Get(ctx, req)
+		require.NoError(t, err)
+		require.Equal(t, uint64(10), result.Index)
+	})
+}
+
+func TestStore_expireLocked_RemovesFromHeap(t *testing.T) {
+	store := NewStore(hclog.New(nil))
+
+	e := &entry{expiry: ttlcache.NewEntry("key"), stop: func() {}}
+	store.byKey["key"] = e
+	store.expiryHeap.Push(e.expiry, time.Millisecond)
+
+	store.lock.Lock()
+	store.expireLocked(e.expiry)
+	store.lock.Unlock()
+
+	require.Equal(t, ttlcache.NotIndexed, e.expiry.Index(),
+		"expireLocked must remove the entry from the heap, or Next will keep returning it forever")
+	require.Nil(t, store.expiryHeap.Next().Entry)
+}
+
+func TestStore_InvalidateToken_DoesNotResurrectOrphanedExpiry(t *testing.T) {
+	store := NewStore(hclog.New(nil))
+
+	e := &entry{token: "abcd", expiry: ttlcache.NewEntry("key"), stop: func() {}, requests: 1}
+	store.byKey["key"] = e
+
+	store.InvalidateToken("abcd")
+	require.True(t, e.invalidated)
+
+	// Simulate the in-flight Get/Notify call (started before invalidation)
+	// finishing afterwards: its deferred cleanup must not re-push this
+	// entry's expiry, since the key may since have been reused by a fresh
+	// entry that the heap push would otherwise corrupt.
+	store.lock.Lock()
+	e.requests--
+	if e.requests == 0 && !e.invalidated {
+		store.expiryHeap.Push(e.expiry, idleTTL)
+	}
+	store.lock.Unlock()
+
+	require.Equal(t, ttlcache.NotIndexed, e.expiry.Index())
+}
+
 func runStep(t *testing.T, name string, fn func(t *testing.T)) {
 	t.Helper()
 	if !t.Run(name, fn) {
@@ -0,0 +1,92 @@
+package submatview
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/consul/proto/pbservice"
+	"github.com/hashicorp/consul/proto/pbsubscribe"
+)
+
+// TestStreamingClient is a StreamClient that replays a queue of events to
+// its caller, for use in tests that exercise a Materializer without a real
+// gRPC subscription.
+type TestStreamingClient struct {
+	pbsubscribe.StateChangeSubscription_SubscribeClient
+	namespace string
+
+	events chan eventOrError
+	ctx    context.Context
+}
+
+type eventOrError struct {
+	event *pbsubscribe.Event
+	err   error
+}
+
+// NewTestStreamingClient returns a TestStreamingClient with an empty event
+// queue. ns is stored on the client; Subscribe and Recv do not use it, and
+// queued events are returned exactly as given.
+func NewTestStreamingClient(ns string) *TestStreamingClient {
+	return &TestStreamingClient{
+		namespace: ns,
+		events:    make(chan eventOrError, 32),
+	}
+}
+
+// Subscribe implements StreamClient.
+func (c *TestStreamingClient) Subscribe(ctx context.Context, _ *pbsubscribe.SubscribeRequest) (pbsubscribe.StateChangeSubscription_SubscribeClient, error) {
+	c.ctx = ctx
+	return c, nil
+}
+
+// QueueEvents appends events to be returned from Recv, in order.
+func (c *TestStreamingClient) QueueEvents(events ...*pbsubscribe.Event) {
+	for _, event := range events {
+		c.events <- eventOrError{event: event}
+	}
+}
+
+// QueueErr causes the next Recv to return err.
+func (c *TestStreamingClient) QueueErr(err error) {
+	c.events <- eventOrError{err: err}
+}
+
+// Recv implements pbsubscribe.StateChangeSubscription_SubscribeClient.
+func (c *TestStreamingClient) Recv() (*pbsubscribe.Event, error) {
+	select {
+	case item := <-c.events:
+		return item.event, item.err
+	case <-c.ctx.Done():
+		return nil, c.ctx.Err()
+	}
+}
+
+func newEndOfSnapshotEvent(index uint64) *pbsubscribe.Event {
+	return &pbsubscribe.Event{
+		Index:   index,
+		Payload: &pbsubscribe.Event_EndOfSnapshot{EndOfSnapshot: true},
+	}
+}
+
+func newNewSnapshotToFollowEvent() *pbsubscribe.Event {
+	return &pbsubscribe.Event{
+		Payload: &pbsubscribe.Event_NewSnapshotToFollow{NewSnapshotToFollow: true},
+	}
+}
+
+func newEventServiceHealthRegister(index uint64, nodeNum int, svc string) *pbsubscribe.Event {
+	node := fmt.Sprintf("node%d", nodeNum)
+	return &pbsubscribe.Event{
+		Index: index,
+		Payload: &pbsubscribe.Event_ServiceHealth{
+			ServiceHealth: &pbsubscribe.ServiceHealthUpdate{
+				Op: pbsubscribe.CatalogOp_Register,
+				CheckServiceNode: &pbservice.CheckServiceNode{
+					Node:    &pbservice.Node{Node: node},
+					Service: &pbservice.NodeService{ID: svc, Service: svc},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,143 @@
+// Package ttlcache provides a heap of entries ordered by expiry time, used by
+// cache implementations that need to evict idle entries after a TTL.
+package ttlcache
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// NotIndexed is returned by Entry.Index when the entry is not currently
+// tracked by an ExpiryHeap.
+const NotIndexed = -1
+
+// Entry tracks the expiry time of a single item. The zero value is not
+// usable, use NewEntry to construct one.
+type Entry struct {
+	// Key uniquely identifies the entry this Entry is tracking the expiry of.
+	Key string
+	// Expires is the time at which the entry should be evicted.
+	Expires time.Time
+
+	heapIndex int
+}
+
+// NewEntry returns an Entry for key that is not tracked by any ExpiryHeap.
+func NewEntry(key string) *Entry {
+	return &Entry{Key: key, heapIndex: NotIndexed}
+}
+
+// Index returns the entry's position in the heap, or NotIndexed if the entry
+// is nil or not currently in a heap.
+func (e *Entry) Index() int {
+	if e == nil {
+		return NotIndexed
+	}
+	return e.heapIndex
+}
+
+// NextExpiry is returned by ExpiryHeap.Next.
+type NextExpiry struct {
+	// Entry is the next entry that will expire, or nil if the heap is empty.
+	Entry *Entry
+	// Wait fires when Entry is due to expire. It is nil if the heap is empty.
+	Wait <-chan time.Time
+}
+
+// ExpiryHeap is a heap of Entry, ordered by Expires, with a NotifyCh that
+// fires whenever an entry is pushed so that a waiter blocked on the previous
+// Next().Wait can recompute it.
+type ExpiryHeap struct {
+	lock sync.Mutex
+	heap entryHeap
+
+	// NotifyCh is sent to whenever an entry is pushed, since that may change
+	// which entry is next to expire. Remove does not send to it.
+	NotifyCh chan struct{}
+}
+
+// NewExpiryHeap creates an empty ExpiryHeap ready for use.
+func NewExpiryHeap() *ExpiryHeap {
+	h := &ExpiryHeap{
+		NotifyCh: make(chan struct{}, 1),
+	}
+	heap.Init(&h.heap)
+	return h
+}
+
+// Push adds entry to the heap, setting its expiry. entry must not already be
+// tracked by a heap.
+func (h *ExpiryHeap) Push(entry *Entry, ttl time.Duration) {
+	h.lock.Lock()
+	entry.Expires = time.Now().Add(ttl)
+	heap.Push(&h.heap, entry)
+	h.lock.Unlock()
+
+	h.notify()
+}
+
+// Remove removes the entry at idx from the heap.
+func (h *ExpiryHeap) Remove(idx int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if idx < 0 || idx >= len(h.heap) {
+		return
+	}
+	heap.Remove(&h.heap, idx)
+}
+
+// Next returns the entry with the earliest expiry, and a channel that fires
+// once that entry is due to expire. Call Next again after Wait fires, or
+// after NotifyCh fires, to recompute it.
+func (h *ExpiryHeap) Next() NextExpiry {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if len(h.heap) == 0 {
+		return NextExpiry{}
+	}
+
+	entry := h.heap[0]
+	return NextExpiry{Entry: entry, Wait: time.After(time.Until(entry.Expires))}
+}
+
+func (h *ExpiryHeap) notify() {
+	select {
+	case h.NotifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// entryHeap implements container/heap.Interface over *Entry ordered by
+// Expires, keeping each Entry's heapIndex up to date.
+type entryHeap []*Entry
+
+func (h entryHeap) Len() int { return len(h) }
+
+func (h entryHeap) Less(i, j int) bool {
+	return h[i].Expires.Before(h[j].Expires)
+}
+
+func (h entryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+
+func (h *entryHeap) Push(x interface{}) {
+	entry := x.(*Entry)
+	entry.heapIndex = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *entryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	entry.heapIndex = NotIndexed
+	*h = old[:n-1]
+	return entry
+}